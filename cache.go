@@ -1,29 +1,50 @@
 package cache
 
 import (
+	"errors"
 	"math/bits"
 	"sync"
 	"sync/atomic"
 )
 
+// ErrCacheFull is returned by Add when the cache is full and every slot is
+// pinned by an outstanding Handle, so no entry can be evicted to make room.
+var ErrCacheFull = errors.New("cache: full and every slot is pinned")
+
 type item[K comparable, V any] struct {
 	key   K      // item key
 	value V      // item value
+	cost  int64  // item cost, used by AddWithCost
 	bit   uint64 // bit mask
 }
 
+// pair is a key value pair queued for an OnEvict or OnRemove callback.
+type pair[K comparable, V any] struct {
+	key   K
+	value V
+}
+
 // Cache stores a finite number of key value pairs where keys are unique.
 // Adding a new key value pair in a full cache result in overriding an
 // existing key value pair using the second chance algorithm which yields
 // efficiency similar to lru.
 type Cache[K comparable, V any] struct {
-	mu       sync.RWMutex
-	idx      map[K]int       // index of keys to items
-	items    []item[K, V]    // table of cached items
-	bits     []atomic.Uint64 // bit map of ejectable items or free slots
-	handIdx  int             // hand index in bits
-	handMask uint64          // hand mask of bits to examine
-	len      int             // number of used slots
+	mu        sync.RWMutex
+	idx       map[K]int                  // index of keys to items
+	items     []item[K, V]               // table of cached items
+	bits      []atomic.Uint64            // bit map of ejectable items or free slots
+	handIdx   int                        // hand index in bits
+	handMask  uint64                     // hand mask of bits to examine
+	len       int                        // number of used slots
+	cost      int64                      // sum of the costs of entries added with AddWithCost
+	capCost   int64                      // cost budget enforced by AddWithCost, 0 disables it
+	onEvict   func(K, V)                 // called outside mu when an entry is evicted
+	onRemove  func(K, V)                 // called outside mu when an entry is removed
+	pinned    []atomic.Int32             // per-slot pin count held by outstanding Handles
+	numPinned int                        // number of slots with a non-zero pin count
+	epoch     uint64                     // bumped by Init, Reset and ReadSnapshot to invalidate outstanding Handles
+	encode    func(K, V) ([]byte, error) // WriteSnapshot codec, nil uses the gob based default
+	decode    func([]byte) (K, V, error) // ReadSnapshot codec, nil uses the gob based default
 }
 
 // New instantiate a new cache with key of type K and value of type V.
@@ -43,15 +64,28 @@ func (c *Cache[K, V]) Init(size int) {
 	c.idx = make(map[K]int, size)
 	c.items = make([]item[K, V], size)
 	c.bits = make([]atomic.Uint64, size/64)
+	c.pinned = make([]atomic.Int32, size)
 	c.handIdx = 0
 	c.handMask = ^uint64(0)
 	c.bits[0].Store(^uint64(0)) // initialize to all ejectable
+	c.epoch++
 	c.mu.Unlock()
 }
 
-// Reset resets the cache in the state it was just after Init.
+// Reset resets the cache in the state it was just after Init. Any
+// outstanding Handle obtained from GetHandle becomes invalid: its Value
+// remains readable, but Release becomes a no-op, even if the same key is
+// later re-added and assigned the same slot.
 func (c *Cache[K, V]) Reset() {
 	c.mu.Lock()
+	onRemove := c.onRemove
+	var removed []pair[K, V]
+	if onRemove != nil {
+		removed = make([]pair[K, V], c.len)
+		for i := 0; i < c.len; i++ {
+			removed[i] = pair[K, V]{c.items[i].key, c.items[i].value}
+		}
+	}
 	clear(c.idx)
 	c.len = 0
 	// cleanup items to avoid memory leak
@@ -60,7 +94,48 @@ func (c *Cache[K, V]) Reset() {
 	}
 	c.handIdx = 0
 	c.handMask = ^uint64(0)
+	c.cost = 0
+	c.capCost = 0
+	for i := range c.pinned {
+		c.pinned[i].Store(0)
+	}
+	c.numPinned = 0
+	c.epoch++
 	c.mu.Unlock()
+	notify(onRemove, removed)
+}
+
+// SetOnEvict sets the callback invoked each time an entry is evicted to
+// make room for another, e.g. from Add, AddWithCost or a cost budget
+// shrink triggered by SetCapacity. Pass nil to disable it. The callback
+// runs outside the cache lock, so it may safely call back into the cache.
+// When a single call evicts several entries, the callback runs once per
+// entry, in eviction order.
+func (c *Cache[K, V]) SetOnEvict(f func(K, V)) {
+	c.mu.Lock()
+	c.onEvict = f
+	c.mu.Unlock()
+}
+
+// SetOnRemove sets the callback invoked each time an entry is removed by
+// Delete or Reset. Pass nil to disable it. The callback runs outside the
+// cache lock, so it may safely call back into the cache. Reset runs it
+// once per entry it held, after the cache has been cleared.
+func (c *Cache[K, V]) SetOnRemove(f func(K, V)) {
+	c.mu.Lock()
+	c.onRemove = f
+	c.mu.Unlock()
+}
+
+// notify calls f for every queued pair, in order. It must only be called
+// after the cache lock has been released.
+func notify[K comparable, V any](f func(K, V), queued []pair[K, V]) {
+	if f == nil {
+		return
+	}
+	for _, p := range queued {
+		f(p.key, p.value)
+	}
 }
 
 // Cap returns the maximum capacity of the cache.
@@ -73,6 +148,28 @@ func (c *Cache[K, V]) Len() int {
 	return c.len
 }
 
+// Cost returns the sum of the costs of the entries currently cached, as
+// tracked by AddWithCost. Entries added with Add have a cost of zero.
+func (c *Cache[K, V]) Cost() int64 {
+	c.mu.RLock()
+	cost := c.cost
+	c.mu.RUnlock()
+	return cost
+}
+
+// SetCapacity sets the cost budget enforced by AddWithCost and evicts
+// entries until the total cost fits within it. A cost of 0 disables cost
+// based eviction. Evictions triggered by a shrink are reported to OnEvict
+// in eviction order, one call per entry, after the cache lock is released.
+func (c *Cache[K, V]) SetCapacity(cost int64) {
+	c.mu.Lock()
+	c.capCost = cost
+	onEvict := c.onEvict
+	evicted := c.shrinkToCost(nil, -1)
+	c.mu.Unlock()
+	notify(onEvict, evicted)
+}
+
 // Get returns the value associated to the given key and true when it is found in the
 // cache. Otherwise it returns false and the default value for the value type.
 func (c *Cache[K, V]) Get(key K) (value V, ok bool) {
@@ -95,10 +192,82 @@ func (c *Cache[K, V]) Has(key K) bool {
 	return ok
 }
 
+// Handle pins a cached entry against automatic eviction by Add, AddWithCost
+// and a cost budget shrink, while it is held. Call Release once done with
+// it. A Handle is opaque: its key is only held by its release function, so
+// it does not carry the cache's key type.
+type Handle[V any] struct {
+	value V
+	epoch uint64
+	unpin func(epoch uint64)
+	done  atomic.Bool
+}
+
+// Value returns the pinned value.
+func (h *Handle[V]) Value() V {
+	return h.value
+}
+
+// Release unpins the entry. Pins are reference counted, so the entry
+// remains protected from automatic eviction until every outstanding Handle
+// to it has been released. It is safe to call Release exactly once;
+// further calls are no-ops. Releasing a Handle obtained before a Reset (or
+// Init, or ReadSnapshot) of the cache is also a no-op, even if the slot it
+// pinned has since been reused by an unrelated entry.
+func (h *Handle[V]) Release() {
+	if h.done.CompareAndSwap(false, true) {
+		h.unpin(h.epoch)
+	}
+}
+
+// GetHandle returns a Handle pinning the entry for key against automatic
+// eviction, and true when key is found in the cache. Otherwise it returns
+// nil and false. While at least one Handle to an entry is outstanding, the
+// second chance hand skips it, and Add returns ErrCacheFull rather than
+// evicting it; Delete is also a no-op on a pinned key. Call Release once
+// done with the handle.
+func (c *Cache[K, V]) GetHandle(key K) (*Handle[V], bool) {
+	c.mu.Lock()
+	idx, ok := c.idx[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	if c.pinned[idx].Add(1) == 1 {
+		c.numPinned++
+		c.bits[idx/64].And(c.items[idx].bit)
+	}
+	value := c.items[idx].value
+	epoch := c.epoch
+	c.mu.Unlock()
+	unpin := func(epoch uint64) { c.unpin(key, epoch) }
+	return &Handle[V]{value: value, epoch: epoch, unpin: unpin}, true
+}
+
+// unpin decrements the pin count of the slot currently holding key,
+// restoring normal second chance eviction once it reaches zero. The slot is
+// looked up by key because removeAt may have moved the pinned entry, with
+// its pin count, to another slot since the handle was obtained. It is a
+// no-op if epoch no longer matches the cache's current epoch, e.g. because
+// the cache was Reset, re-Init'd or restored from a snapshot since the
+// handle was obtained, which may since have re-added key as an unrelated
+// entry.
+func (c *Cache[K, V]) unpin(key K, epoch uint64) {
+	c.mu.Lock()
+	if idx, ok := c.idx[key]; ok && epoch == c.epoch {
+		if c.pinned[idx].Add(-1) == 0 {
+			c.numPinned--
+		}
+	}
+	c.mu.Unlock()
+}
+
 // Add adds the key value pair to the cache. It returns false and the default value for
 // the type when the pair could be inserted in a free slot. Otherwise it returns true and
-// the overwritten or discarded value which may be recycled.
-func (c *Cache[K, V]) Add(key K, value V) (oldValue V, ok bool) {
+// the overwritten or discarded value which may be recycled. If the cache is full and
+// every slot is pinned by an outstanding Handle, no entry is evicted and Add returns
+// ErrCacheFull.
+func (c *Cache[K, V]) Add(key K, value V) (oldValue V, ok bool, err error) {
 	c.mu.Lock()
 	var idx int
 	// if key already in cache
@@ -123,7 +292,48 @@ func (c *Cache[K, V]) Add(key K, value V) (oldValue V, ok bool) {
 	}
 
 	// executed only when cache is full
+	if c.numPinned >= len(c.items) {
+		c.mu.Unlock()
+		return oldValue, false, ErrCacheFull
+	}
 	// locate the next element we can eject
+	var found bool
+	if c.numPinned == 0 {
+		idx, found = c.evictIdx(), true
+	} else {
+		idx, found = c.evictIdxUnpinned()
+	}
+	if !found {
+		c.mu.Unlock()
+		return oldValue, false, ErrCacheFull
+	}
+
+	oldValue = c.items[idx].value
+	ok = true
+	evictedKey := c.items[idx].key
+	onEvict := c.onEvict
+	c.cost -= c.items[idx].cost
+
+	delete(c.idx, evictedKey)
+	c.idx[key] = idx
+
+	c.items[idx].key = key
+	c.items[idx].value = value
+	c.items[idx].cost = 0
+	c.bits[idx/64].And(c.items[idx].bit)
+
+	c.mu.Unlock()
+	if onEvict != nil {
+		onEvict(evictedKey, oldValue)
+	}
+	return
+}
+
+// evictIdx locates, using the second chance hand, the index of the next
+// item that can be ejected, and advances the hand past it. When the hand
+// finds no ejectable item, it gives the items it sweeps past a second
+// chance by marking them ejectable, so it always returns an index.
+func (c *Cache[K, V]) evictIdx() int {
 	// set bits in handMask are the bits to check
 	mbits := c.bits[c.handIdx].Load() & c.handMask
 	if mbits == 0 {
@@ -141,57 +351,229 @@ func (c *Cache[K, V]) Add(key K, value V) (oldValue V, ok bool) {
 	}
 	// the less significant bit set in mbits is the element we eject
 	bit := bits.TrailingZeros64(mbits)
-	idx = c.handIdx*64 | bit
-
-	oldValue = c.items[idx].value
-	ok = true
+	idx := c.handIdx*64 | bit
 
-	delete(c.idx, c.items[idx].key)
-	c.idx[key] = idx
+	if c.handMask = ^uint64(0) << (bit + 1); c.handMask == 0 {
+		c.handMask = ^uint64(0)
+		if c.handIdx++; c.handIdx == len(c.bits) {
+			c.handIdx = 0
+		}
+	}
+	return idx
+}
 
-	c.items[idx].key = key
-	c.items[idx].value = value
-	c.bits[c.handIdx].And(c.items[idx].bit)
+// evictIdxUnpinned is like evictIdx but skips slots pinned by an
+// outstanding Handle. It scans the whole table at most once looking for an
+// already ejectable, unpinned slot, then, if none is found, gives every
+// unpinned slot a second chance and scans once more. It returns ok=false
+// when every slot is pinned.
+func (c *Cache[K, V]) evictIdxUnpinned() (idx int, ok bool) {
+	n := len(c.items)
+	start := c.handIdx * 64
+	for i := 0; i < n; i++ {
+		j := (start + i) % n
+		bit := uint64(1) << (j % 64)
+		if c.pinned[j].Load() == 0 && c.bits[j/64].Load()&bit == bit {
+			c.advanceHandPast(j)
+			return j, true
+		}
+	}
+	for i := 0; i < n; i++ {
+		if c.pinned[i].Load() == 0 {
+			c.bits[i/64].Or(uint64(1) << (i % 64))
+		}
+	}
+	for i := 0; i < n; i++ {
+		j := (start + i) % n
+		if c.pinned[j].Load() == 0 {
+			c.advanceHandPast(j)
+			return j, true
+		}
+	}
+	return 0, false
+}
 
-	if c.handMask = ^uint64(0) << (bit + 1); c.handMask == 0 {
+// advanceHandPast moves the second chance hand to just past slot j.
+func (c *Cache[K, V]) advanceHandPast(j int) {
+	c.handIdx = j / 64
+	if c.handMask = ^uint64(0) << (j%64 + 1); c.handMask == 0 {
 		c.handMask = ^uint64(0)
 		if c.handIdx++; c.handIdx == len(c.bits) {
 			c.handIdx = 0
 		}
 	}
+}
+
+// AddWithCost is like Add but additionally tracks a per-entry cost. When a
+// cost budget has been set with SetCapacity, other entries may be evicted
+// to keep the total cost of the cache within the budget. The returned
+// value and bool only report the entry directly overwritten or discarded
+// by this call, as Add does; entries evicted solely to satisfy the cost
+// budget are not reported. If the cache is full and every slot is pinned by
+// an outstanding Handle, AddWithCost is a no-op and returns false and the
+// default value, the same way Add reports ErrCacheFull.
+func (c *Cache[K, V]) AddWithCost(key K, value V, cost int64) (oldValue V, ok bool) {
+	c.mu.Lock()
+	onEvict := c.onEvict
+	var evicted []pair[K, V]
+
+	// if key already in cache, override value and cost
+	if idx, exists := c.idx[key]; exists {
+		oldValue = c.items[idx].value
+		c.cost += cost - c.items[idx].cost
+		c.items[idx].value = value
+		c.items[idx].cost = cost
+		c.bits[idx/64].And(c.items[idx].bit)
+		evicted = c.shrinkToCost(evicted, idx)
+		c.mu.Unlock()
+		notify(onEvict, evicted)
+		return oldValue, true
+	}
+
+	var idx int
+	if c.len < len(c.items) {
+		// cache not yet full, append item leaving hand unmodified
+		idx = c.len
+		c.items[idx].bit = ^(uint64(1) << (idx % 64))
+		c.idx[key] = idx
+		c.len++
+	} else {
+		// cache full, eject the next item the hand finds
+		var found bool
+		if c.numPinned == 0 {
+			idx, found = c.evictIdx(), true
+		} else {
+			idx, found = c.evictIdxUnpinned()
+		}
+		if !found {
+			c.mu.Unlock()
+			notify(onEvict, evicted)
+			return oldValue, false
+		}
+		oldValue = c.items[idx].value
+		ok = true
+		evicted = append(evicted, pair[K, V]{c.items[idx].key, oldValue})
+		c.cost -= c.items[idx].cost
+		delete(c.idx, c.items[idx].key)
+		c.idx[key] = idx
+	}
+	c.items[idx].key = key
+	c.items[idx].value = value
+	c.items[idx].cost = cost
+	c.cost += cost
+	c.bits[idx/64].And(c.items[idx].bit)
 
+	evicted = c.shrinkToCost(evicted, idx)
 	c.mu.Unlock()
+	notify(onEvict, evicted)
 	return
 }
 
+// shrinkToCost evicts entries until the total cost fits within the
+// configured budget, appending each evicted pair to evicted and returning
+// it. It is a no-op when no budget has been set with SetCapacity. protect
+// is the index of an entry, such as the one just added by AddWithCost,
+// that must never be evicted; pass -1 when there is none, e.g. from
+// SetCapacity. Unlike Add, which only ever ejects once the cache is full
+// and therefore never looks past the occupied slots, shrinkToCost may run
+// while slots are still free, so it scans the occupied range directly
+// instead of reusing the hand used by Add.
+func (c *Cache[K, V]) shrinkToCost(evicted []pair[K, V], protect int) []pair[K, V] {
+	if c.capCost <= 0 {
+		return evicted
+	}
+	for c.cost > c.capCost && c.len > 0 {
+		idx, ok := c.evictIdxInOccupied(protect)
+		if !ok {
+			return evicted
+		}
+		evicted = append(evicted, pair[K, V]{c.items[idx].key, c.items[idx].value})
+		c.cost -= c.items[idx].cost
+		last := c.len - 1
+		c.removeAt(idx)
+		if protect == last && idx != last {
+			// the protected entry was moved into the evicted slot
+			protect = idx
+		}
+	}
+	return evicted
+}
+
+// evictIdxInOccupied returns the index, other than protect, of an
+// occupied, ejectable, unpinned slot. If none is currently ejectable, every
+// occupied, unpinned slot other than protect is given a second chance and
+// the first one is returned. Slots pinned by an outstanding Handle are
+// never returned.
+func (c *Cache[K, V]) evictIdxInOccupied(protect int) (idx int, ok bool) {
+	for i := 0; i < c.len; i++ {
+		if i == protect || c.pinned[i].Load() != 0 {
+			continue
+		}
+		bit := uint64(1) << (i % 64)
+		if c.bits[i/64].Load()&bit == bit {
+			return i, true
+		}
+	}
+	for i := 0; i < c.len; i++ {
+		if i != protect && c.pinned[i].Load() == 0 {
+			c.bits[i/64].Or(uint64(1) << (i % 64))
+		}
+	}
+	for i := 0; i < c.len; i++ {
+		if i != protect && c.pinned[i].Load() == 0 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
 // Delete returns the deleted value and true, when key is found in the cache to
 // allow recycling the value. Otherwise, it returns the default value and false.
+// Delete is a no-op and returns false when key is pinned by an outstanding
+// Handle; release all handles to it first.
 func (c *Cache[K, V]) Delete(key K) (value V, ok bool) {
 	c.mu.Lock()
-	var idx int
-	if idx, ok = c.idx[key]; ok {
+	onRemove := c.onRemove
+	idx, found := c.idx[key]
+	if found && c.pinned[idx].Load() == 0 {
+		ok = true
 		value = c.items[idx].value
-		delete(c.idx, key)
-		c.len--
-		if c.len != idx {
-			// replace deleted item with last item
-			c.idx[c.items[c.len].key] = idx
-			c.items[idx].key = c.items[c.len].key
-			c.items[idx].value = c.items[c.len].value
-			c.items[c.len] = item[K, V]{}
-			if c.bits[c.len/64].Load()&(^c.items[c.len].bit) == 0 {
-				c.bits[idx/64].And(c.items[idx].bit)
-			} else {
-				c.bits[idx/64].Or(^c.items[idx].bit)
-			}
-		}
-		// avoid memory leak
-		c.items[c.len] = item[K, V]{}
+		c.cost -= c.items[idx].cost
+		c.removeAt(idx)
 	}
 	c.mu.Unlock()
+	if ok {
+		notify(onRemove, []pair[K, V]{{key, value}})
+	}
 	return
 }
 
+// removeAt removes the item at position idx, compacting the table by
+// moving the last item, along with its pin count, into its place.
+func (c *Cache[K, V]) removeAt(idx int) {
+	delete(c.idx, c.items[idx].key)
+	c.len--
+	if c.len != idx {
+		// replace deleted item with last item
+		c.idx[c.items[c.len].key] = idx
+		c.items[idx].key = c.items[c.len].key
+		c.items[idx].value = c.items[c.len].value
+		c.items[idx].cost = c.items[c.len].cost
+		if p := c.pinned[c.len].Load(); p != 0 {
+			c.pinned[idx].Store(p)
+			c.pinned[c.len].Store(0)
+		}
+		c.items[c.len] = item[K, V]{}
+		if c.bits[c.len/64].Load()&(^c.items[c.len].bit) == 0 {
+			c.bits[idx/64].And(c.items[idx].bit)
+		} else {
+			c.bits[idx/64].Or(^c.items[idx].bit)
+		}
+	}
+	// avoid memory leak
+	c.items[c.len] = item[K, V]{}
+}
+
 // Items locks the cache and iterates over elements.
 func (c *Cache[K, V]) Items() func(yield func(K, V) bool) {
 	return func(yield func(key K, value V) bool) {
@@ -127,7 +127,7 @@ func TestCacheIter(t *testing.T) {
 
 	// fill cache
 	for i := range c.Cap() {
-		if v, ok := c.Add(i, i); ok || v != 0 {
+		if v, ok, _ := c.Add(i, i); ok || v != 0 {
 			t.Fatalf("for %d expect no ejection", i)
 		}
 		if err := c.check(); err != nil {
@@ -168,7 +168,7 @@ func TestCacheAdd(t *testing.T) {
 
 	// fill cache
 	for i := range c.Cap() {
-		if v, ok := c.Add(i, i); ok || v != 0 {
+		if v, ok, _ := c.Add(i, i); ok || v != 0 {
 			t.Fatalf("for %d expect no ejection", i)
 		}
 		if err := c.check(); err != nil {
@@ -188,7 +188,7 @@ func TestCacheAdd(t *testing.T) {
 
 	// should eject item 253 and replace with 256
 	c.setEjectable(253, true)
-	if v, ok := c.Add(256, 256); !ok || v != 253 {
+	if v, ok, _ := c.Add(256, 256); !ok || v != 253 {
 		t.Fatalf("expect to eject 253")
 	}
 	if err := c.check(); err != nil {
@@ -208,7 +208,7 @@ func TestCacheAdd(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if v, ok := c.Add(253, 253); ok || v != 0 {
+	if v, ok, _ := c.Add(253, 253); ok || v != 0 {
 		t.Fatalf("expect no ejection")
 	}
 	if err := c.check(); err != nil {
@@ -216,7 +216,7 @@ func TestCacheAdd(t *testing.T) {
 	}
 
 	l := c.Len()
-	if v, ok := c.Add(253, 257); !ok || v != 253 {
+	if v, ok, _ := c.Add(253, 257); !ok || v != 253 {
 		t.Fatalf("expect value 253 ejected")
 	}
 	if c.Len() != l {
@@ -242,7 +242,7 @@ func TestCacheAdd(t *testing.T) {
 	}
 
 	c.setEjectable(255, true)
-	if v, ok := c.Add(256, 256); !ok || v != 255 {
+	if v, ok, _ := c.Add(256, 256); !ok || v != 255 {
 		t.Fatalf("expect to eject 255")
 	}
 	if err := c.check(); err != nil {
@@ -255,7 +255,7 @@ func TestCacheAdd(t *testing.T) {
 	c.handIdx = len(c.bits) - 1
 
 	c.setEjectable(10, true)
-	if v, ok := c.Add(257, 257); !ok || v != 10 {
+	if v, ok, _ := c.Add(257, 257); !ok || v != 10 {
 		t.Fatalf("expect to eject 10, got %v", v)
 	}
 	if err := c.check(); err != nil {
@@ -267,7 +267,7 @@ func TestCacheAdd(t *testing.T) {
 	}
 	c.handIdx = 1
 	c.setEjectable(11, true)
-	if v, ok := c.Add(258, 258); !ok || v != 11 {
+	if v, ok, _ := c.Add(258, 258); !ok || v != 11 {
 		t.Fatalf("expect to eject 10, got %v", v)
 	}
 	if err := c.check(); err != nil {
@@ -275,6 +275,233 @@ func TestCacheAdd(t *testing.T) {
 	}
 }
 
+func TestCacheAddWithCost(t *testing.T) {
+	const size = 64
+	c := New[int, int](size)
+
+	c.SetCapacity(10)
+	for i := range 5 {
+		if v, ok := c.AddWithCost(i, i, 2); ok || v != 0 {
+			t.Fatalf("for %d expect no eviction", i)
+		}
+	}
+	if c.Cost() != 10 {
+		t.Fatalf("expect cost 10, got %d", c.Cost())
+	}
+	if c.Len() != 5 {
+		t.Fatalf("expect len 5, got %d", c.Len())
+	}
+
+	// adding one more over budget must evict other entries, not grow Len
+	c.AddWithCost(5, 5, 2)
+	if c.Cost() != 10 {
+		t.Fatalf("expect cost still 10, got %d", c.Cost())
+	}
+	if c.Len() != 5 {
+		t.Fatalf("expect len still 5, got %d", c.Len())
+	}
+	if _, ok := c.Get(5); !ok {
+		t.Fatal("expect 5 to be present")
+	}
+
+	// overriding an entry with a larger cost must also trigger eviction
+	c.AddWithCost(5, 5, 10)
+	if c.Cost() > 10 {
+		t.Fatalf("expect cost within budget, got %d", c.Cost())
+	}
+
+	// shrinking the budget at runtime evicts down to fit
+	c.SetCapacity(2)
+	if c.Cost() > 2 {
+		t.Fatalf("expect cost within shrunk budget, got %d", c.Cost())
+	}
+
+	// growing the budget back does not evict anything
+	l := c.Len()
+	c.SetCapacity(1000)
+	if c.Len() != l {
+		t.Fatalf("expect len unchanged, got %d", c.Len())
+	}
+}
+
+func TestCacheOnEvict(t *testing.T) {
+	const size = 64
+	c := New[int, int](size)
+
+	var evicted []int
+	c.SetOnEvict(func(k, v int) {
+		if k != v {
+			t.Fatalf("expect key %d to equal value %d", k, v)
+		}
+		evicted = append(evicted, k)
+	})
+
+	for i := range c.Cap() {
+		c.Add(i, i)
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("expect no eviction while filling, got %v", evicted)
+	}
+
+	if _, ok, _ := c.Add(size, size); !ok {
+		t.Fatal("expect eviction")
+	}
+	if len(evicted) != 1 {
+		t.Fatalf("expect exactly one eviction, got %v", evicted)
+	}
+
+	// a cost budget shrink evicting several entries must call OnEvict once
+	// per entry, and it must be safe to call back into the cache from it
+	evicted = nil
+	c.Reset()
+	c.SetOnEvict(func(k, v int) {
+		evicted = append(evicted, k)
+		c.Has(k) // callback must not deadlock when re-entering the cache
+	})
+	c.SetCapacity(3)
+	for i := range 3 {
+		c.AddWithCost(i, i, 1)
+	}
+	c.AddWithCost(3, 3, 3)
+	if len(evicted) != 3 {
+		t.Fatalf("expect 3 entries evicted to make room, got %v", evicted)
+	}
+}
+
+func TestCacheOnRemove(t *testing.T) {
+	const size = 64
+	c := New[int, int](size)
+	for i := range c.Cap() {
+		c.Add(i, i)
+	}
+
+	var removed []int
+	c.SetOnRemove(func(k, v int) {
+		if k != v {
+			t.Fatalf("expect key %d to equal value %d", k, v)
+		}
+		removed = append(removed, k)
+	})
+
+	c.Delete(0)
+	if len(removed) != 1 || removed[0] != 0 {
+		t.Fatalf("expect 0 removed, got %v", removed)
+	}
+
+	removed = nil
+	n := c.Len()
+	c.Reset()
+	if len(removed) != n {
+		t.Fatalf("expect %d entries removed by Reset, got %d", n, len(removed))
+	}
+}
+
+func TestCacheGetHandle(t *testing.T) {
+	const size = 64
+	c := New[int, int](size)
+	for i := range c.Cap() {
+		c.Add(i, i)
+	}
+
+	if _, ok := c.GetHandle(-1); ok {
+		t.Fatal("expect no handle for a missing key")
+	}
+
+	h, ok := c.GetHandle(0)
+	if !ok || h.Value() != 0 {
+		t.Fatalf("expect handle for key 0 with value 0, got %v %v", h, ok)
+	}
+
+	// the cache is full; adding keys must never evict the pinned key 0
+	for i := c.Cap(); i < 2*c.Cap()-1; i++ {
+		c.Add(i, i)
+		if !c.Has(0) {
+			t.Fatalf("pinned key 0 evicted while adding %d", i)
+		}
+	}
+
+	// pin every other slot and verify Add reports ErrCacheFull once all
+	// slots are pinned
+	var keys []int
+	c.Items()(func(k, v int) bool {
+		if k != 0 {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	var handles []*Handle[int]
+	for _, k := range keys {
+		hh, ok := c.GetHandle(k)
+		if !ok {
+			t.Fatalf("expect handle for key %d", k)
+		}
+		handles = append(handles, hh)
+	}
+	if _, _, err := c.Add(-2, -2); err != ErrCacheFull {
+		t.Fatalf("expect ErrCacheFull, got %v", err)
+	}
+
+	// Delete refuses a pinned key
+	if _, ok := c.Delete(0); ok {
+		t.Fatal("expect Delete to be a no-op on a pinned key")
+	}
+
+	h.Release()
+	h.Release() // a second Release is a no-op
+	if _, _, err := c.Add(-2, -2); err != nil {
+		t.Fatalf("expect key 0 to be evictable after Release, got %v", err)
+	}
+	if c.Has(0) {
+		t.Fatal("expect key 0 to have been evicted after its handle was released")
+	}
+
+	for _, hh := range handles {
+		hh.Release()
+	}
+	if v, ok := c.Delete(-2); !ok || v != -2 {
+		t.Fatalf("expect key -2 to be deletable once unpinned, got %v %v", v, ok)
+	}
+}
+
+func TestCacheGetHandleStaleAfterReset(t *testing.T) {
+	const size = 64
+	c := New[int, int](size)
+	c.Add(0, 0)
+
+	h, ok := c.GetHandle(0)
+	if !ok {
+		t.Fatal("expect handle for key 0")
+	}
+
+	c.Reset()
+	// re-add a key that lands back in the same slot the stale handle
+	// pinned, then pin it for real.
+	c.Add(0, 1)
+	h2, ok := c.GetHandle(0)
+	if !ok {
+		t.Fatal("expect handle for key 0 after reset")
+	}
+
+	// the stale handle must not affect the post-reset pin accounting.
+	h.Release()
+	h.Release() // a second Release is still a no-op
+
+	for i := c.Cap(); i < 2*c.Cap()-1; i++ {
+		c.Add(i, i)
+		if !c.Has(0) {
+			t.Fatalf("pinned key 0 evicted while adding %d", i)
+		}
+	}
+
+	h2.Release()
+	if _, _, err := c.Add(-2, -2); err != nil {
+		t.Fatalf("expect key 0 to be evictable once its real handle is released, got %v", err)
+	}
+	if c.Has(0) {
+		t.Fatal("expect key 0 to have been evicted after its handle was released")
+	}
+}
+
 func BenchmarkInt(b *testing.B) {
 
 	const size = 10240
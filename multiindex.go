@@ -0,0 +1,164 @@
+package cache
+
+import "sync"
+
+// Index describes a secondary index maintained by a MultiIndexCache: Name
+// identifies it for GetBy and DeleteBy, and Extract derives the secondary
+// key from a cached value. The value returned by Extract must be
+// comparable, since it is used as a map key.
+type Index[V any] struct {
+	Name    string
+	Extract func(V) any
+}
+
+// MultiIndexCache layers secondary indexes, each keyed by a field extracted
+// from the cached value, on top of a Cache[K, V] keyed by a primary key.
+// GetBy and DeleteBy look up an entry by a secondary key in O(1), same as
+// the primary key. The secondary indexes stay consistent with the primary
+// cache: whenever an entry is overwritten, deleted, or evicted to make
+// room, every secondary index entry pointing at it is removed in the same
+// critical section, so a lookup never returns a stale or half-updated
+// entry.
+type MultiIndexCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	cache   Cache[K, V]
+	indexes []Index[V]
+	byIndex []map[any]K // one reverse map per index, same order as indexes
+}
+
+// NewMultiIndexCache instantiates a MultiIndexCache with the given primary
+// capacity and secondary indexes. Index names must be unique; GetBy and
+// DeleteBy panic when given a name that was not registered.
+func NewMultiIndexCache[K comparable, V any](size int, indexes ...Index[V]) *MultiIndexCache[K, V] {
+	mc := &MultiIndexCache[K, V]{
+		indexes: indexes,
+		byIndex: make([]map[any]K, len(indexes)),
+	}
+	mc.cache.Init(size)
+	for i := range mc.byIndex {
+		mc.byIndex[i] = make(map[any]K)
+	}
+	mc.cache.SetOnEvict(mc.unindex)
+	mc.cache.SetOnRemove(mc.unindex)
+	return mc
+}
+
+// indexOf returns the position of the index registered under name, or -1.
+func (mc *MultiIndexCache[K, V]) indexOf(name string) int {
+	for i, idx := range mc.indexes {
+		if idx.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// index adds value, stored under key, to every secondary index. mu must be
+// held.
+func (mc *MultiIndexCache[K, V]) index(key K, value V) {
+	for i, idx := range mc.indexes {
+		mc.byIndex[i][idx.Extract(value)] = key
+	}
+}
+
+// unindex removes value's entry from every secondary index, provided it
+// still points at key, i.e. it was not already superseded by a more recent
+// entry sharing the same secondary key. It is registered as the underlying
+// cache's OnEvict and OnRemove callback, both of which only ever fire from
+// within a call made with mu held, so mu is already held whenever unindex
+// runs.
+func (mc *MultiIndexCache[K, V]) unindex(key K, value V) {
+	for i, idx := range mc.indexes {
+		sk := idx.Extract(value)
+		if mc.byIndex[i][sk] == key {
+			delete(mc.byIndex[i], sk)
+		}
+	}
+}
+
+// Add adds the key value pair to the cache and indexes value under every
+// registered secondary index, atomically dropping the index entries of any
+// entry it overrides or evicts to make room. See Cache.Add for the meaning
+// of the returned values.
+func (mc *MultiIndexCache[K, V]) Add(key K, value V) (oldValue V, ok bool, err error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	existed := mc.cache.Has(key)
+	oldValue, ok, err = mc.cache.Add(key, value)
+	if err != nil {
+		return
+	}
+	if existed {
+		// Add silently overrides an existing key without evicting, so
+		// unlike a genuine eviction this is not reported to unindex.
+		mc.unindex(key, oldValue)
+	}
+	mc.index(key, value)
+	return
+}
+
+// Get returns the value associated to the given key and true when it is
+// found in the cache. Otherwise it returns false and the default value for
+// the value type.
+func (mc *MultiIndexCache[K, V]) Get(key K) (value V, ok bool) {
+	return mc.cache.Get(key)
+}
+
+// Has reports whether key is present in the cache.
+func (mc *MultiIndexCache[K, V]) Has(key K) bool {
+	return mc.cache.Has(key)
+}
+
+// Delete returns the deleted value and true, when key is found in the
+// cache, removing it from every secondary index in the same critical
+// section. Otherwise, it returns the default value and false.
+func (mc *MultiIndexCache[K, V]) Delete(key K) (value V, ok bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.cache.Delete(key)
+}
+
+// GetBy returns the value indexed under key in the secondary index name,
+// and true when found. It panics if name does not match a registered
+// index.
+func (mc *MultiIndexCache[K, V]) GetBy(name string, key any) (value V, ok bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	i := mc.indexOf(name)
+	if i < 0 {
+		panic("cache: unknown index " + name)
+	}
+	pk, found := mc.byIndex[i][key]
+	if !found {
+		return value, false
+	}
+	return mc.cache.Get(pk)
+}
+
+// DeleteBy deletes the entry indexed under key in the secondary index
+// name, returning its value and true when found, and removes it from
+// every other secondary index in the same critical section. It panics if
+// name does not match a registered index.
+func (mc *MultiIndexCache[K, V]) DeleteBy(name string, key any) (value V, ok bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	i := mc.indexOf(name)
+	if i < 0 {
+		panic("cache: unknown index " + name)
+	}
+	pk, found := mc.byIndex[i][key]
+	if !found {
+		return value, false
+	}
+	return mc.cache.Delete(pk)
+}
+
+// Len returns the number of key value pairs in the cache.
+func (mc *MultiIndexCache[K, V]) Len() int {
+	return mc.cache.Len()
+}
+
+// Cap returns the maximum capacity of the cache.
+func (mc *MultiIndexCache[K, V]) Cap() int {
+	return mc.cache.Cap()
+}
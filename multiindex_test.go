@@ -0,0 +1,102 @@
+package cache
+
+import "testing"
+
+type user struct {
+	id    int
+	email string
+	token string
+}
+
+func newUserCache(size int) *MultiIndexCache[int, user] {
+	return NewMultiIndexCache[int, user](size,
+		Index[user]{Name: "email", Extract: func(u user) any { return u.email }},
+		Index[user]{Name: "token", Extract: func(u user) any { return u.token }},
+	)
+}
+
+func TestMultiIndexCacheGetByDeleteBy(t *testing.T) {
+	uc := newUserCache(64)
+
+	alice := user{id: 1, email: "alice@example.com", token: "tok-alice"}
+	uc.Add(alice.id, alice)
+
+	if v, ok := uc.Get(1); !ok || v != alice {
+		t.Fatalf("Get(1): expect %v true, got %v %v", alice, v, ok)
+	}
+	if v, ok := uc.GetBy("email", "alice@example.com"); !ok || v != alice {
+		t.Fatalf("GetBy(email): expect %v true, got %v %v", alice, v, ok)
+	}
+	if v, ok := uc.GetBy("token", "tok-alice"); !ok || v != alice {
+		t.Fatalf("GetBy(token): expect %v true, got %v %v", alice, v, ok)
+	}
+	if _, ok := uc.GetBy("email", "bob@example.com"); ok {
+		t.Fatal("expect GetBy(email) to miss for an unknown email")
+	}
+
+	if v, ok := uc.DeleteBy("email", "alice@example.com"); !ok || v != alice {
+		t.Fatalf("DeleteBy(email): expect %v true, got %v %v", alice, v, ok)
+	}
+	if uc.Has(1) {
+		t.Fatal("expect primary entry gone after DeleteBy")
+	}
+	if _, ok := uc.GetBy("token", "tok-alice"); ok {
+		t.Fatal("expect token index entry gone after DeleteBy on email")
+	}
+}
+
+func TestMultiIndexCacheGetByUnknownIndexPanics(t *testing.T) {
+	uc := newUserCache(8)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect GetBy to panic on an unregistered index name")
+		}
+	}()
+	uc.GetBy("nickname", "whatever")
+}
+
+func TestMultiIndexCacheOverrideReindexes(t *testing.T) {
+	uc := newUserCache(8)
+
+	uc.Add(1, user{id: 1, email: "a@example.com", token: "t1"})
+	uc.Add(1, user{id: 1, email: "b@example.com", token: "t2"})
+
+	if _, ok := uc.GetBy("email", "a@example.com"); ok {
+		t.Fatal("expect stale email index entry to be gone after override")
+	}
+	if _, ok := uc.GetBy("token", "t1"); ok {
+		t.Fatal("expect stale token index entry to be gone after override")
+	}
+	if v, ok := uc.GetBy("email", "b@example.com"); !ok || v.token != "t2" {
+		t.Fatalf("GetBy(email) after override: expect updated entry, got %v %v", v, ok)
+	}
+}
+
+func TestMultiIndexCacheEvictionClearsIndexes(t *testing.T) {
+	const size = 64
+	uc := newUserCache(size)
+	for i := 0; i < size; i++ {
+		u := user{id: i, email: string(rune('a' + i%26)), token: "tok"}
+		uc.Add(i, u)
+	}
+	// every entry shares a handful of emails and the same token, so only the
+	// most recent key for each secondary key should remain indexed.
+	if got, want := len(uc.byIndex[0]), 26; got != want {
+		t.Fatalf("expect %d distinct emails indexed, got %d", want, got)
+	}
+
+	// force an eviction and verify the evicted entry's index rows are gone.
+	evictedKey := 0
+	evictedUser, _ := uc.Get(evictedKey)
+	if _, ok, err := uc.Add(size, user{id: size, email: "z", token: "tok"}); err != nil || !ok {
+		t.Fatalf("expect an eviction when adding past capacity, got ok=%v err=%v", ok, err)
+	}
+	if uc.Has(evictedKey) {
+		t.Skip("second chance algorithm evicted a different slot than expected")
+	}
+	for i, idx := range uc.indexes {
+		if uc.byIndex[i][idx.Extract(evictedUser)] == evictedKey {
+			t.Fatalf("expect index %q entry for evicted key to be gone", idx.Name)
+		}
+	}
+}
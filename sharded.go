@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"fmt"
+	"hash/maphash"
+)
+
+// Hasher computes a hash for a key of type K. A good Hasher spreads keys
+// uniformly across the full range of uint64 so that ShardedCache can use it
+// to pick a shard without skewing load toward a few of them.
+type Hasher[K comparable] func(key K) uint64
+
+// seed is shared by every Hasher returned by defaultHasher, so that hashing
+// the same key always picks the same shard for the lifetime of the process.
+var seed = maphash.MakeSeed()
+
+// defaultHasher returns a sensible Hasher[K] for K: strings are hashed
+// directly with maphash, integer types are spread with a cheap
+// multiplicative mix, and every other comparable type falls back to hashing
+// its fmt.Sprint representation with maphash.
+func defaultHasher[K comparable]() Hasher[K] {
+	var zero K
+	switch any(zero).(type) {
+	case string:
+		return func(key K) uint64 { return maphash.String(seed, any(key).(string)) }
+	case int:
+		return func(key K) uint64 { return mix64(uint64(any(key).(int))) }
+	case int8:
+		return func(key K) uint64 { return mix64(uint64(any(key).(int8))) }
+	case int16:
+		return func(key K) uint64 { return mix64(uint64(any(key).(int16))) }
+	case int32:
+		return func(key K) uint64 { return mix64(uint64(any(key).(int32))) }
+	case int64:
+		return func(key K) uint64 { return mix64(uint64(any(key).(int64))) }
+	case uint:
+		return func(key K) uint64 { return mix64(uint64(any(key).(uint))) }
+	case uint8:
+		return func(key K) uint64 { return mix64(uint64(any(key).(uint8))) }
+	case uint16:
+		return func(key K) uint64 { return mix64(uint64(any(key).(uint16))) }
+	case uint32:
+		return func(key K) uint64 { return mix64(uint64(any(key).(uint32))) }
+	case uint64:
+		return func(key K) uint64 { return mix64(any(key).(uint64)) }
+	case uintptr:
+		return func(key K) uint64 { return mix64(uint64(any(key).(uintptr))) }
+	default:
+		return func(key K) uint64 { return maphash.String(seed, fmt.Sprint(key)) }
+	}
+}
+
+// mix64 is the splitmix64 finalizer, used to spread small integer keys
+// across the full uint64 range.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ShardedCache spreads key value pairs across a power of two number of
+// independent Cache[K, V] shards, each with its own lock and second chance
+// state, to reduce write contention under concurrent access. A key always
+// hashes to the same shard, so operations on different keys only contend
+// when the keys happen to land in the same shard.
+type ShardedCache[K comparable, V any] struct {
+	shards []Cache[K, V]
+	mask   uint64
+	hash   Hasher[K]
+}
+
+// NewSharded instantiates a ShardedCache with shardCount shards, each sized
+// to hold about size/shardCount key value pairs, using hash to pick the
+// shard for a key. shardCount is rounded up to the next power of two. Pass
+// a nil hash to use the default hasher for K, see defaultHasher.
+func NewSharded[K comparable, V any](shardCount, size int, hash Hasher[K]) *ShardedCache[K, V] {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	n := 1
+	for n < shardCount {
+		n <<= 1
+	}
+	if hash == nil {
+		hash = defaultHasher[K]()
+	}
+	perShard := (size + n - 1) / n
+	sc := &ShardedCache[K, V]{
+		shards: make([]Cache[K, V], n),
+		mask:   uint64(n - 1),
+		hash:   hash,
+	}
+	for i := range sc.shards {
+		sc.shards[i].Init(perShard)
+	}
+	return sc
+}
+
+// ShardCount returns the number of shards, always a power of two.
+func (sc *ShardedCache[K, V]) ShardCount() int {
+	return len(sc.shards)
+}
+
+// shardFor returns the shard responsible for key.
+func (sc *ShardedCache[K, V]) shardFor(key K) *Cache[K, V] {
+	return &sc.shards[sc.hash(key)&sc.mask]
+}
+
+// Get returns the value associated to the given key and true when it is
+// found in the cache. Otherwise it returns false and the default value for
+// the value type.
+func (sc *ShardedCache[K, V]) Get(key K) (value V, ok bool) {
+	return sc.shardFor(key).Get(key)
+}
+
+// Has reports whether key is present in the cache.
+func (sc *ShardedCache[K, V]) Has(key K) bool {
+	return sc.shardFor(key).Has(key)
+}
+
+// Add adds the key value pair to the shard key hashes to. See Cache.Add for
+// the meaning of the returned values.
+func (sc *ShardedCache[K, V]) Add(key K, value V) (oldValue V, ok bool, err error) {
+	return sc.shardFor(key).Add(key, value)
+}
+
+// Delete returns the deleted value and true, when key is found in the
+// cache. Otherwise, it returns the default value and false.
+func (sc *ShardedCache[K, V]) Delete(key K) (value V, ok bool) {
+	return sc.shardFor(key).Delete(key)
+}
+
+// Len returns the number of key value pairs in the cache, summed across
+// every shard.
+func (sc *ShardedCache[K, V]) Len() int {
+	n := 0
+	for i := range sc.shards {
+		n += sc.shards[i].Len()
+	}
+	return n
+}
+
+// Cap returns the maximum capacity of the cache, summed across every shard.
+func (sc *ShardedCache[K, V]) Cap() int {
+	n := 0
+	for i := range sc.shards {
+		n += sc.shards[i].Cap()
+	}
+	return n
+}
+
+// Items locks each shard in turn and iterates over its elements. Unlike
+// Cache.Items, no single lock is held across the whole iteration, so a
+// concurrent writer may be observed mid-way, in a different shard, while
+// the iteration is in progress.
+func (sc *ShardedCache[K, V]) Items() func(yield func(K, V) bool) {
+	return func(yield func(key K, value V) bool) {
+		for i := range sc.shards {
+			stop := false
+			sc.shards[i].Items()(func(k K, v V) bool {
+				if !yield(k, v) {
+					stop = true
+					return false
+				}
+				return true
+			})
+			if stop {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewShardedRoundsShardCount(t *testing.T) {
+	for _, tc := range []struct{ want, got int }{
+		{1, 1}, {2, 2}, {4, 3}, {4, 4}, {8, 5},
+	} {
+		sc := NewSharded[int, int](tc.got, 128, nil)
+		if n := sc.ShardCount(); n != tc.want {
+			t.Fatalf("shardCount(%d): expect %d, got %d", tc.got, tc.want, n)
+		}
+	}
+}
+
+func TestShardedCacheBasic(t *testing.T) {
+	// size is generous enough that every shard can hold all of the keys
+	// below even in the worst case where the hasher sends them all to the
+	// same shard, so no Add should ever evict.
+	const shards, keys = 4, 256
+	const size = shards * keys
+	sc := NewSharded[int, int](shards, size, nil)
+	if got := sc.Cap(); got != size {
+		t.Fatalf("expect capacity %d, got %d", size, got)
+	}
+
+	for i := 0; i < keys; i++ {
+		if _, ok, err := sc.Add(i, i*i); err != nil {
+			t.Fatalf("Add(%d): unexpected error %v", i, err)
+		} else if ok {
+			t.Fatalf("Add(%d): unexpected eviction", i)
+		}
+	}
+	if got := sc.Len(); got != keys {
+		t.Fatalf("expect len %d, got %d", keys, got)
+	}
+
+	for i := 0; i < keys; i++ {
+		if v, ok := sc.Get(i); !ok || v != i*i {
+			t.Fatalf("Get(%d): expect %d true, got %d %v", i, i*i, v, ok)
+		}
+		if !sc.Has(i) {
+			t.Fatalf("Has(%d): expect true", i)
+		}
+	}
+
+	seen := make(map[int]int, keys)
+	sc.Items()(func(k, v int) bool {
+		seen[k] = v
+		return true
+	})
+	if len(seen) != keys {
+		t.Fatalf("Items: expect %d entries, got %d", keys, len(seen))
+	}
+
+	for i := 0; i < keys; i += 2 {
+		if v, ok := sc.Delete(i); !ok || v != i*i {
+			t.Fatalf("Delete(%d): expect %d true, got %d %v", i, i*i, v, ok)
+		}
+	}
+	if got, want := sc.Len(), keys/2; got != want {
+		t.Fatalf("expect len %d after deletes, got %d", want, got)
+	}
+}
+
+func TestShardedCacheCustomHasher(t *testing.T) {
+	// a hasher that always picks shard 0 must still behave correctly, just
+	// without any of the concurrency benefit.
+	sc := NewSharded[string, int](8, 64, Hasher[string](func(string) uint64 { return 0 }))
+	sc.Add("a", 1)
+	sc.Add("b", 2)
+	if v, ok := sc.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a): expect 1 true, got %d %v", v, ok)
+	}
+	if v, ok := sc.Get("b"); !ok || v != 2 {
+		t.Fatalf("Get(b): expect 2 true, got %d %v", v, ok)
+	}
+}
+
+func TestDefaultHasherDeterministic(t *testing.T) {
+	h := defaultHasher[string]()
+	if h("same") != h("same") {
+		t.Fatal("expect defaultHasher[string] to be deterministic")
+	}
+
+	hi := defaultHasher[int]()
+	if hi(42) != hi(42) {
+		t.Fatal("expect defaultHasher[int] to be deterministic")
+	}
+
+	type point struct{ x, y int }
+	hp := defaultHasher[point]()
+	if hp(point{1, 2}) != hp(point{1, 2}) {
+		t.Fatal("expect defaultHasher fallback to be deterministic")
+	}
+}
+
+func BenchmarkCacheAddParallel(b *testing.B) {
+	const size = 1 << 16
+	c := New[int, int](size)
+	var n atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			k := int(n.Add(1))
+			c.Add(k%size, k)
+		}
+	})
+}
+
+func BenchmarkShardedCacheAddParallel(b *testing.B) {
+	const size = 1 << 16
+	for _, shards := range []int{2, 4, 8, 16} {
+		b.Run("shards"+strconv.Itoa(shards), func(b *testing.B) {
+			sc := NewSharded[int, int](shards, size, nil)
+			var n atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					k := int(n.Add(1))
+					sc.Add(k%size, k)
+				}
+			})
+		})
+	}
+}
+
+// TestShardedCacheConcurrentAdd exercises ShardedCache under concurrent
+// writers to catch data races; run with -race.
+func TestShardedCacheConcurrentAdd(t *testing.T) {
+	const goroutines, perGoroutine = 16, 1000
+	sc := NewSharded[int, int](8, 4096, nil)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				sc.Add(g*perGoroutine+i, i)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
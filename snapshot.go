@@ -0,0 +1,273 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// snapshotMagic identifies a cache snapshot stream, followed by a version
+// number so the format can evolve without breaking older readers.
+var snapshotMagic = [4]byte{'C', 'S', 'N', 'P'}
+
+const snapshotVersion = 1
+
+// SetCodec installs the encoder and decoder WriteSnapshot and ReadSnapshot
+// use to serialize a key value pair. Pass nil for either to fall back to a
+// gob based codec, which works whenever K and V are gob-encodable.
+func (c *Cache[K, V]) SetCodec(enc func(K, V) ([]byte, error), dec func([]byte) (K, V, error)) {
+	c.mu.Lock()
+	c.encode = enc
+	c.decode = dec
+	c.mu.Unlock()
+}
+
+// snapshotPair is the gob wire type used by the default codec. gob only
+// serializes exported fields, hence the wrapper around key and value.
+type snapshotPair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+func defaultEncode[K comparable, V any](key K, value V) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshotPair[K, V]{Key: key, Value: value}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func defaultDecode[K comparable, V any](b []byte) (key K, value V, err error) {
+	var p snapshotPair[K, V]
+	if err = gob.NewDecoder(bytes.NewReader(b)).Decode(&p); err != nil {
+		return key, value, err
+	}
+	return p.Key, p.Value, nil
+}
+
+// WriteSnapshot writes every key value pair currently cached to w, along
+// with the bookkeeping needed to restore eviction state: each entry's
+// ejectable bit and cost, the second chance hand position, and the cost
+// budget set with SetCapacity. Keys and values are serialized with the
+// codec installed by SetCodec, or a gob based default when none was set.
+// WriteSnapshot takes a read lock for its whole duration, so it reflects a
+// single consistent point in time.
+func (c *Cache[K, V]) WriteSnapshot(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	encode := c.encode
+	if encode == nil {
+		encode = defaultEncode[K, V]
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(snapshotMagic[:]); err != nil {
+		return err
+	}
+	if err := writeUint64(bw, snapshotVersion); err != nil {
+		return err
+	}
+	if err := writeUint64(bw, uint64(len(c.items))); err != nil {
+		return err
+	}
+	if err := writeUint64(bw, uint64(c.len)); err != nil {
+		return err
+	}
+	if err := writeInt64(bw, c.capCost); err != nil {
+		return err
+	}
+	if err := writeInt64(bw, c.cost); err != nil {
+		return err
+	}
+	if err := writeUint64(bw, uint64(c.handIdx)); err != nil {
+		return err
+	}
+	if err := writeUint64(bw, c.handMask); err != nil {
+		return err
+	}
+
+	for i := 0; i < c.len; i++ {
+		data, err := encode(c.items[i].key, c.items[i].value)
+		if err != nil {
+			return fmt.Errorf("cache: encode entry %d: %w", i, err)
+		}
+		ejectable := byte(0)
+		if bit := uint64(1) << (i % 64); c.bits[i/64].Load()&bit == bit {
+			ejectable = 1
+		}
+		if err := bw.WriteByte(ejectable); err != nil {
+			return err
+		}
+		if err := writeInt64(bw, c.items[i].cost); err != nil {
+			return err
+		}
+		if err := writeUint64(bw, uint64(len(data))); err != nil {
+			return err
+		}
+		if _, err := bw.Write(data); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadSnapshot replaces the cache's content with the snapshot read from r,
+// discarding whatever it currently holds without calling OnRemove, and
+// invalidating any outstanding Handle obtained from GetHandle before the
+// call, as Reset does. Keys and values are deserialized with the codec
+// installed by SetCodec, or a gob based default when none was set. When the
+// snapshot holds more entries
+// than the cache's current capacity, the extra entries are dropped; when it
+// holds fewer, the remaining slots are left free. The second chance hand
+// position is only restored when the snapshot's capacity matches the
+// cache's current capacity exactly; otherwise the hand restarts at the
+// first slot, as it does after Init. If a read or decode error truncates
+// the restore partway through, the cache is left holding just the entries
+// it managed to decode before the error, rather than the stale pre-call
+// content or an inconsistent mix of the two.
+func (c *Cache[K, V]) ReadSnapshot(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	var gotMagic [4]byte
+	if _, err := io.ReadFull(br, gotMagic[:]); err != nil {
+		return fmt.Errorf("cache: read snapshot header: %w", err)
+	}
+	if gotMagic != snapshotMagic {
+		return fmt.Errorf("cache: not a cache snapshot")
+	}
+	version, err := readUint64(br)
+	if err != nil {
+		return fmt.Errorf("cache: read snapshot version: %w", err)
+	}
+	if version != snapshotVersion {
+		return fmt.Errorf("cache: unsupported snapshot version %d", version)
+	}
+	srcCap, err := readUint64(br)
+	if err != nil {
+		return fmt.Errorf("cache: read snapshot capacity: %w", err)
+	}
+	length, err := readUint64(br)
+	if err != nil {
+		return fmt.Errorf("cache: read snapshot length: %w", err)
+	}
+	capCost, err := readInt64(br)
+	if err != nil {
+		return fmt.Errorf("cache: read snapshot cost budget: %w", err)
+	}
+	// c.cost is recomputed from each entry's cost below rather than trusted
+	// from the stream, so a truncated restore can't leave it out of sync.
+	if _, err = readInt64(br); err != nil {
+		return fmt.Errorf("cache: read snapshot cost: %w", err)
+	}
+	handIdx, err := readUint64(br)
+	if err != nil {
+		return fmt.Errorf("cache: read snapshot hand index: %w", err)
+	}
+	handMask, err := readUint64(br)
+	if err != nil {
+		return fmt.Errorf("cache: read snapshot hand mask: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	decode := c.decode
+	if decode == nil {
+		decode = defaultDecode[K, V]
+	}
+
+	dstCap := uint64(len(c.items))
+	kept := length
+	if dstCap < kept {
+		kept = dstCap
+	}
+
+	clear(c.idx)
+	for i := range c.items {
+		c.items[i] = item[K, V]{}
+	}
+	for i := range c.bits {
+		c.bits[i].Store(^uint64(0))
+	}
+	for i := range c.pinned {
+		c.pinned[i].Store(0)
+	}
+	c.numPinned = 0
+	c.epoch++ // invalidate any outstanding Handle obtained before this restore
+	c.len = 0
+	c.cost = 0
+	c.capCost = capCost
+
+	for i := uint64(0); i < length; i++ {
+		ejectable, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("cache: read snapshot entry %d: %w", i, err)
+		}
+		itemCost, err := readInt64(br)
+		if err != nil {
+			return fmt.Errorf("cache: read snapshot entry %d cost: %w", i, err)
+		}
+		dataLen, err := readUint64(br)
+		if err != nil {
+			return fmt.Errorf("cache: read snapshot entry %d length: %w", i, err)
+		}
+		data := make([]byte, dataLen)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("cache: read snapshot entry %d: %w", i, err)
+		}
+		if i >= kept {
+			continue
+		}
+		key, value, err := decode(data)
+		if err != nil {
+			return fmt.Errorf("cache: decode entry %d: %w", i, err)
+		}
+		idx := int(i)
+		c.items[idx].key = key
+		c.items[idx].value = value
+		c.items[idx].cost = itemCost
+		c.items[idx].bit = ^(uint64(1) << (idx % 64))
+		c.idx[key] = idx
+		bit := uint64(1) << (idx % 64)
+		if ejectable != 0 {
+			c.bits[idx/64].Or(bit)
+		} else {
+			c.bits[idx/64].And(^bit)
+		}
+		c.cost += itemCost
+		c.len = idx + 1
+	}
+
+	c.handIdx = 0
+	c.handMask = ^uint64(0)
+	if srcCap == dstCap {
+		c.handIdx = int(handIdx)
+		c.handMask = handMask
+	}
+
+	return nil
+}
+
+func writeUint64(w io.Writer, v uint64) error {
+	return binary.Write(w, binary.BigEndian, v)
+}
+
+func writeInt64(w io.Writer, v int64) error {
+	return binary.Write(w, binary.BigEndian, v)
+}
+
+func readUint64(r io.Reader) (uint64, error) {
+	var v uint64
+	err := binary.Read(r, binary.BigEndian, &v)
+	return v, err
+}
+
+func readInt64(r io.Reader) (int64, error) {
+	var v int64
+	err := binary.Read(r, binary.BigEndian, &v)
+	return v, err
+}
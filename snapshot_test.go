@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	const size = 128
+	c := New[int, string](size)
+	for i := 0; i < size; i++ {
+		c.Add(i, strconv.Itoa(i))
+	}
+	// mark a few entries ejectable so the ejectable bits aren't all
+	// identical, and exercise the AddWithCost path for the cost fields.
+	c.setEjectable(20, true)
+	c.setEjectable(21, true)
+	c.SetCapacity(1000)
+	for i := 0; i < 10; i++ {
+		c.AddWithCost(i, strconv.Itoa(i), 50)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	restored := New[int, string](size)
+	if err := restored.ReadSnapshot(&buf); err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+
+	if got, want := restored.Len(), c.Len(); got != want {
+		t.Fatalf("expect len %d, got %d", want, got)
+	}
+	if got, want := restored.Cost(), c.Cost(); got != want {
+		t.Fatalf("expect cost %d, got %d", want, got)
+	}
+	for k, v := range c.Items() {
+		got, ok := restored.Get(k)
+		if !ok || got != v {
+			t.Fatalf("key %d: expect %q true, got %q %v", k, v, got, ok)
+		}
+	}
+	if err := restored.check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotTruncatesToSmallerCapacity(t *testing.T) {
+	const srcSize = 128
+	c := New[int, int](srcSize)
+	for i := 0; i < srcSize; i++ {
+		c.Add(i, i)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	const dstSize = 64
+	restored := New[int, int](dstSize)
+	if err := restored.ReadSnapshot(&buf); err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	if got, want := restored.Len(), dstSize; got != want {
+		t.Fatalf("expect truncated len %d, got %d", want, got)
+	}
+	if err := restored.check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotLeavesFreeSlotsForLargerCapacity(t *testing.T) {
+	const srcSize = 64
+	c := New[int, int](srcSize)
+	for i := 0; i < srcSize; i++ {
+		c.Add(i, i)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	const dstSize = 192
+	restored := New[int, int](dstSize)
+	if err := restored.ReadSnapshot(&buf); err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	if got, want := restored.Len(), srcSize; got != want {
+		t.Fatalf("expect len %d, got %d", want, got)
+	}
+	if got, want := restored.Cap(), dstSize; got != want {
+		t.Fatalf("expect capacity %d, got %d", want, got)
+	}
+	// the free slots left over must still be usable.
+	for i := srcSize; i < dstSize; i++ {
+		if _, ok, err := restored.Add(1000+i, i); err != nil || ok {
+			t.Fatalf("expect a free slot for %d, got ok=%v err=%v", i, ok, err)
+		}
+	}
+	if err := restored.check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotRejectsBadMagic(t *testing.T) {
+	c := New[int, int](64)
+	if err := c.ReadSnapshot(bytes.NewReader([]byte("not a snapshot"))); err == nil {
+		t.Fatal("expect an error for a non-snapshot stream")
+	}
+}
+
+func TestSnapshotTruncatedStreamLeavesConsistentCache(t *testing.T) {
+	const size = 64
+	c := New[int, int](size)
+	for i := 0; i < size; i++ {
+		c.Add(i, i)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+	// cut the stream off partway through the entries, after the header and
+	// a few whole entries but before the rest.
+	truncated := buf.Bytes()[:buf.Len()*2/3]
+
+	restored := New[int, int](size)
+	for i := 0; i < size; i++ {
+		restored.Add(1000+i, i)
+	}
+	if err := restored.ReadSnapshot(bytes.NewReader(truncated)); err == nil {
+		t.Fatal("expect an error for a truncated snapshot")
+	}
+	if err := restored.check(); err != nil {
+		t.Fatalf("cache left inconsistent after a truncated ReadSnapshot: %v", err)
+	}
+}
+
+func TestSnapshotCustomCodec(t *testing.T) {
+	c := New[int, int](32)
+	c.SetCodec(
+		func(k, v int) ([]byte, error) {
+			return []byte(strconv.Itoa(k) + ":" + strconv.Itoa(v)), nil
+		},
+		func(b []byte) (k, v int, err error) {
+			s := string(b)
+			i := bytes.IndexByte(b, ':')
+			if k, err = strconv.Atoi(s[:i]); err != nil {
+				return
+			}
+			v, err = strconv.Atoi(s[i+1:])
+			return
+		},
+	)
+	for i := 0; i < 10; i++ {
+		c.Add(i, i*2)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	restored := New[int, int](32)
+	restored.SetCodec(c.encode, c.decode)
+	if err := restored.ReadSnapshot(&buf); err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if v, ok := restored.Get(i); !ok || v != i*2 {
+			t.Fatalf("key %d: expect %d true, got %d %v", i, i*2, v, ok)
+		}
+	}
+}